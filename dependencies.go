@@ -4,28 +4,21 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"go/parser"
-	"go/scanner"
-	"go/token"
-	"io/ioutil"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
 	"strings"
 	"text/tabwriter"
-)
 
-// Defined by the user at the command line
-var (
-	configFile string
-	inputFile  string
+	"golang.org/x/tools/go/packages"
 )
 
-// Read from the config file provided
+// Defined by the user at the command line
 var (
-	standardLibraryPath string
-	vendorRelPath       string
+	inputFile     string
+	buildTargets  string
+	format        string
+	companyPrefix string
+	localPrefix   string
+	groupSummary  bool
 )
 
 // 300 is the max number of directories possible.
@@ -41,11 +34,25 @@ const unlearnedIndicator = "*"
 
 // Row output formats
 const (
-	noDependenciesRow = "%s\t%d\t%s\t"
-	firstRow          = "%s\t%d\t%s\t%s\t"
+	noDependenciesRow = "%s\t%s\t%s\t"
+	firstRow          = "%s\t%s\t%s\t%s\t"
 	subsequentRows    = "\t\t\t%s\t"
+	targetRow         = "\t\t\t%s [%s]: %s\t"
+	cycleRow          = "\t\t\t(cycle with %s)\t"
+	cgoRow            = "\t\t\t(cgo: %s)\t"
 )
 
+// loadMode is the set of packages.Package fields this tool needs: the
+// import path and on-disk files (NeedName, NeedFiles), the package's
+// direct imports (NeedImports), its transitive imports (NeedDeps), and
+// its module, so vendored/replaced packages resolve to the same
+// Package node as everything else (NeedModule).
+const loadMode = packages.NeedName |
+	packages.NeedFiles |
+	packages.NeedImports |
+	packages.NeedDeps |
+	packages.NeedModule
+
 var outputWriter *tabwriter.Writer = tabwriter.NewWriter(os.Stdout, 0, 0, padding, padChar, 0)
 
 func init() {
@@ -57,81 +64,93 @@ func init() {
 		"A file containing all the packages already learnt",
 	)
 	flag.StringVar(
-		&configFile,
-		"config-file",
-		"config.txt",
-		"A file containing the standard library configuration",
+		&buildTargets,
+		"targets",
+		"",
+		"Comma-separated GOOS/GOARCH pairs (e.g. \"linux/amd64,windows/amd64\") to "+
+			"compute a per-target dependency matrix for. Defaults to the host's "+
+			"own GOOS/GOARCH.",
+	)
+	flag.StringVar(
+		&format,
+		"format",
+		"text",
+		"Output format: text, json, or dot",
+	)
+	flag.StringVar(
+		&companyPrefix,
+		"company-prefix",
+		"appengine",
+		"Import path prefix classified as the \"company\" import group",
+	)
+	flag.StringVar(
+		&localPrefix,
+		"local-prefix",
+		"",
+		"Comma-separated list of import path prefixes classified as the \"local\" "+
+			"import group",
+	)
+	flag.BoolVar(
+		&groupSummary,
+		"group-summary",
+		false,
+		"Print per-package import group counts (e.g. \"stdlib=12 third-party=3 "+
+			"local=1\") instead of the full dependency listing",
 	)
 }
 
 func main() {
 
 	flag.Parse()
-	readConfig()
+	localPrefixes = parseLocalPrefixes(localPrefix)
+
+	// Everything left on the command line after flags is a package
+	// pattern, e.g. "./...", "std", or "example.com/mod/...". Default to
+	// the whole standard library so the tool still works out of the box.
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"std"}
+	}
 
-	NewPackage(path.Join(standardLibraryPath, "C")) // pseudo-directory.
-	if err := filepath.Walk(standardLibraryPath, loadPath); err != nil {
+	if err := loadDependencies(patterns); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	// loadLearnedPkgs looks packages up by import path in pkgsByLocation,
+	// so it has to run after loadDependencies has populated it.
 	if err := loadLearnedPkgs(); err != nil {
 		fmt.Println(err)
 	}
 
-	if err := loadDependencies(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
 	for _, pkg := range pkgsByLocation {
 		pkg.Dependants.Sort()
 		pkg.Dependants.makeUnique()
 	}
 
 	sortByDependencyDepth()
-	printPkgs()
-}
 
-// Read the config file.
-func readConfig() {
-	// Read the Go standard library config from the config file.
-	f, err := os.Open(configFile)
-	defer f.Close()
+	renderer, err := newRenderer(format)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	_scanner := bufio.NewScanner(f)
 
-	lineNumber := 0
-	for _scanner.Scan() {
-		lineNumber++
-		configLine := strings.Split(_scanner.Text(), ":")
-		if len(configLine) != 2 {
-			fmt.Printf("Error parsinng line %d\n", lineNumber)
-			os.Exit(1)
-		}
-		configKey := strings.TrimSpace(configLine[0])
-		configValue := strings.TrimSpace(configLine[1])
-
-		switch configKey {
-		case "standardLibraryPath":
-			standardLibraryPath = configValue
-		case "vendorRelPath":
-			vendorRelPath = configValue
-		default:
-			fmt.Printf("Invalid config key: %s\n", configKey)
-			os.Exit(1)
-		}
+	if err := renderer.RenderAll(pkgsByDependencyDepth); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
 
-// Sort packages by dependency depth.
+// Sort packages by dependency depth. Packages caught in a cycle land
+// together under the cycleDepth bucket rather than being dropped.
 func sortByDependencyDepth() {
 
 	for _, pkg := range pkgsByLocation {
-		depth := pkg.DependencyDepth()
+		depth, err := pkg.DependencyDepth()
+		if err != nil && err != ErrCycle {
+			fmt.Println(err)
+		}
 		li, _ := pkgsByDependencyDepth[depth]
 		pkgsByDependencyDepth[depth] = append(li, pkg)
 	}
@@ -152,9 +171,9 @@ func loadLearnedPkgs() (err error) {
 
 	_scanner := bufio.NewScanner(f)
 	for _scanner.Scan() {
-		pkg := pkgFromImportPath(_scanner.Text())
+		pkg, found := pkgsByLocation[_scanner.Text()]
 
-		if pkg != nil {
+		if found {
 			pkg.Learned = true
 		}
 	}
@@ -162,168 +181,133 @@ func loadLearnedPkgs() (err error) {
 	return
 }
 
-// loadPath loads all the package info in the given path.
-func loadPath(_path string, info os.FileInfo, e error) (err error) {
-
-	if info == nil || e != nil {
-		err = e
-		return
+// loadDependencies runs the go/packages loader over patterns, once per
+// build target (or once with the host's own GOOS/GOARCH when -targets is
+// unset), and walks the resulting import graphs, populating
+// pkgsByLocation along with each Package's Dependencies/Dependants and,
+// when targets are given, DepsByTarget. It replaces the previous approach
+// of walking the standard library by hand and re-parsing every file's
+// import block: the loader asks the real Go build system to resolve
+// imports - including evaluating build constraints for the requested
+// GOOS/GOARCH - so vendored, replaced, platform-conditional, and
+// internal/ packages all come out correctly for any module, not just the
+// standard library.
+func loadDependencies(patterns []string) (err error) {
+
+	targets := parseTargets(buildTargets)
+	if len(targets) == 0 {
+		return loadDependenciesForTarget(patterns, "")
 	}
 
-	if info.IsDir() {
-
-		if _path == standardLibraryPath {
-			return
-		}
-
-		pathBase := filepath.Base(_path)
-		if pathBase == "cmd" || pathBase == "testdata" {
-			err = filepath.SkipDir
+	for _, target := range targets {
+		if err = loadDependenciesForTarget(patterns, target); err != nil {
 			return
 		}
-
-		return
 	}
 
-	err = loadFileInfo(_path)
 	return
 }
 
-// Parse the file and load package information.
-func loadFileInfo(_path string) (err error) {
+// loadDependenciesForTarget loads patterns for a single "GOOS/GOARCH"
+// target (the host's default when target is ""), and walks the result.
+func loadDependenciesForTarget(patterns []string, target string) (err error) {
 
-	if filepath.Ext(_path) != ".go" {
-		return
+	cfg := &packages.Config{Mode: loadMode}
+	if target != "" {
+		goos, goarch := splitTarget(target)
+		cfg.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch)
 	}
 
-	// Test files are ignored.
-	if isTest, _ := filepath.Match("*_test.go", filepath.Base(_path)); isTest {
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
 		return
 	}
 
-	if _, found := pkgsByLocation[filepath.ToSlash(filepath.Dir(_path))]; found {
-		// the package has been added already. The remaining files
-		// needn't be scanned.
+	// packages.Load's own error only covers malformed patterns/config;
+	// per-package failures (a pattern that matches nothing, a package
+	// that fails to build-constrain, syntax errors, ...) land in each
+	// pkg.Errors instead and are silent otherwise. Surface them rather
+	// than reporting a phantom package with no dependencies.
+	if n := packages.PrintErrors(pkgs); n > 0 {
+		err = fmt.Errorf("%d error(s) loading %v for target %q", n, patterns, target)
 		return
 	}
 
-	// We just want the package clause.
-	fileset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fileset, _path, nil, parser.PackageClauseOnly)
-	if err != nil || astFile.Name == nil {
-
-		// if an error occurs in parsing, it's a bad file. Move on.
-		switch err.(type) {
-		case scanner.Error, scanner.ErrorList:
-			err = nil
+	visited := make(map[string]bool, len(pkgsByLocation))
+	for _, pkg := range pkgs {
+		if err = walkPackage(pkg, target, visited); err != nil {
+			return
 		}
-		return
-	}
-
-	// Only if the package name is the same as the directory do we have
-	// a package from the Go standard library.
-	if astFile.Name.Name == filepath.Base(filepath.Dir(_path)) {
-		_, err = NewPackage(path.Dir(filepath.ToSlash(_path)))
 	}
 
 	return
 }
 
-// Loads all dependencies.
-func loadDependencies() (err error) {
+// walkPackage records pkg (if it hasn't been seen before) and recurses
+// into its imports, wiring up Dependencies/Dependants as it goes, and -
+// when target is set - recording the dependency on pkg's DepsByTarget
+// entry for that target. visited guards against revisiting a package
+// within a single target's walk, since diamond dependencies are common
+// in any reasonably sized import graph.
+func walkPackage(pkg *packages.Package, target string, visited map[string]bool) (err error) {
 
-	for _path, pkg := range pkgsByLocation {
+	if visited[pkg.PkgPath] {
+		return
+	}
+	visited[pkg.PkgPath] = true
 
-		if pkg.Name() == "C" {
-			continue
+	p, found := pkgsByLocation[pkg.PkgPath]
+	if !found {
+		if p, err = NewPackage(pkg); err != nil {
+			return
 		}
+		recordCgoSymbols(p, pkg)
+	}
 
-		fileList, e := ioutil.ReadDir(filepath.FromSlash(_path))
-		if e != nil {
-			err = e
+	for _, importedPkg := range pkg.Imports {
+		if err = walkPackage(importedPkg, target, visited); err != nil {
 			return
 		}
 
-		for _, f := range fileList {
-			err = scanFileForDependencies(
-				filepath.Join(filepath.FromSlash(_path), f.Name()),
-				pkg,
-			)
-			if err != nil {
-				return
-			}
-		}
+		dep := pkgsByLocation[importedPkg.PkgPath]
+		p.DependsOn(dep)
+		dep.Dependants = append(dep.Dependants, p)
 
+		if target != "" {
+			p.addTargetDep(target, dep)
+		}
 	}
+
 	return
 }
 
-// scanFileForDependencies assumes the file is a go file that compiles.
-// It scans for any imports in the package with the same name as the
-// directory the go file is in. It ignores test files.
-func scanFileForDependencies(_path string, pkg *Package) (err error) {
-
-	if filepath.Ext(_path) != ".go" {
-		return
-	}
-
-	if isTest, _ := filepath.Match("*_test.go", filepath.Base(_path)); isTest {
-		return
-	}
+// parseTargets splits a comma-separated "-targets" value into its
+// individual "GOOS/GOARCH" entries, trimming whitespace and dropping
+// empty entries.
+func parseTargets(raw string) (targets []string) {
 
-	// Only parse up to the import statement as that is all we need.
-	fileset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fileset, _path, nil, parser.ImportsOnly)
-	if err != nil || astFile.Name == nil {
-		return
+	if raw == "" {
+		return nil
 	}
 
-	if astFile.Name.Name != filepath.Base(pkg.Name()) {
-		// the package name doesn't match the current directory
-		return
-	}
-
-	for _, importSpec := range astFile.Imports {
-		// record each package that the current file depends on
-		importedPkg := pkgFromImportPath(strings.Trim(importSpec.Path.Value, `"`))
-		pkg.DependsOn(importedPkg)
-		importedPkg.Dependants = append(importedPkg.Dependants, pkg)
+	for _, target := range strings.Split(raw, ",") {
+		target = strings.TrimSpace(target)
+		if target != "" {
+			targets = append(targets, target)
+		}
 	}
 
 	return
 }
 
-// importedPkg finds the package corresponding to a given import path.
-func pkgFromImportPath(importPath string) (pkg *Package) {
+// splitTarget splits a single "GOOS/GOARCH" entry into its two parts.
+func splitTarget(target string) (goos, goarch string) {
 
-	var fullPath string
-
-	if isVendor, _ := regexp.MatchString(`golang_org/x/\w*`, importPath); isVendor {
-		fullPath = path.Join(standardLibraryPath, "vendor", importPath)
-	} else {
-		fullPath = path.Join(standardLibraryPath, importPath)
+	parts := strings.SplitN(target, "/", 2)
+	goos = parts[0]
+	if len(parts) == 2 {
+		goarch = parts[1]
 	}
 
-	pkg, _ = pkgsByLocation[fullPath]
 	return
 }
-
-// print the output.
-func printPkgs() {
-
-	defer outputWriter.Flush()
-
-	// skip depth -1 since that is reserved for learned or built-in
-	// packages.
-	for depth := 0; ; depth++ {
-		pkgLi, ok := pkgsByDependencyDepth[depth]
-		if !ok {
-			return
-		}
-
-		// Print each package with the given dependency depth.
-		for _, pkg := range pkgLi {
-			pkg.Write()
-		}
-	}
-}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrCycle is returned by DependencyDepth when pkg sits in a genuine
+// dependency cycle (an SCC of more than one package, or a self-import).
+// Depth is meaningless for such a package; pkg.Cycle lists its cycle
+// partners.
+var ErrCycle = errors.New("cyclic package dependency")
+
+// cycleDepth is the sentinel DependencyDepth returns alongside ErrCycle.
+const cycleDepth = math.MaxInt
+
+var (
+	depthCache    = make(map[*Package]int)
+	sccDepthCache = make(map[int]int)
+	sccOf         = make(map[*Package]int)
+	sccMembers    [][]*Package
+	sccsDone      bool
+)
+
+// computeSCCs runs an iterative (non-recursive, so a graph with tens of
+// thousands of packages can't blow the call stack) Tarjan's algorithm
+// over pkgsByLocation, treating Package.Dependencies as edges. It
+// populates sccOf and sccMembers, and sets Package.Cycle on every
+// package that belongs to a non-trivial component (an SCC of more than
+// one member, or a single package that imports itself). Safe to call
+// more than once; only the first call does any work.
+func computeSCCs() {
+
+	if sccsDone {
+		return
+	}
+	sccsDone = true
+
+	index := make(map[*Package]int, len(pkgsByLocation))
+	lowlink := make(map[*Package]int, len(pkgsByLocation))
+	onStack := make(map[*Package]bool, len(pkgsByLocation))
+	var tarjanStack []*Package
+	nextIndex := 0
+
+	// frame is one level of the explicit DFS stack: the package being
+	// visited, and how far through its Dependencies the walk has got.
+	type frame struct {
+		pkg    *Package
+		depIdx int
+	}
+
+	for _, root := range pkgsByLocation {
+		if _, visited := index[root]; visited {
+			continue
+		}
+
+		var work []*frame
+		push := func(pkg *Package) {
+			index[pkg] = nextIndex
+			lowlink[pkg] = nextIndex
+			nextIndex++
+			tarjanStack = append(tarjanStack, pkg)
+			onStack[pkg] = true
+			work = append(work, &frame{pkg: pkg})
+		}
+		push(root)
+
+		for len(work) > 0 {
+			top := work[len(work)-1]
+
+			if top.depIdx < len(top.pkg.Dependencies) {
+				dep := top.pkg.Dependencies[top.depIdx]
+				top.depIdx++
+
+				if _, visited := index[dep]; !visited {
+					push(dep)
+				} else if onStack[dep] && index[dep] < lowlink[top.pkg] {
+					lowlink[top.pkg] = index[dep]
+				}
+				continue
+			}
+
+			// top.pkg's dependencies have all been visited.
+			work = work[:len(work)-1]
+			if len(work) > 0 {
+				parent := work[len(work)-1]
+				if lowlink[top.pkg] < lowlink[parent.pkg] {
+					lowlink[parent.pkg] = lowlink[top.pkg]
+				}
+			}
+
+			if lowlink[top.pkg] != index[top.pkg] {
+				continue
+			}
+
+			// top.pkg is the root of an SCC: pop it off tarjanStack.
+			var members []*Package
+			for {
+				n := len(tarjanStack) - 1
+				member := tarjanStack[n]
+				tarjanStack = tarjanStack[:n]
+				onStack[member] = false
+				members = append(members, member)
+				if member == top.pkg {
+					break
+				}
+			}
+			recordSCC(members)
+		}
+	}
+}
+
+// recordSCC assigns members a shared SCC index and, if they form a
+// genuine cycle, populates each member's Cycle field with its partners.
+func recordSCC(members []*Package) {
+
+	sccIdx := len(sccMembers)
+	sccMembers = append(sccMembers, members)
+	for _, member := range members {
+		sccOf[member] = sccIdx
+	}
+
+	if len(members) > 1 {
+		for _, member := range members {
+			for _, other := range members {
+				if other != member {
+					member.Cycle = append(member.Cycle, other)
+				}
+			}
+		}
+		return
+	}
+
+	// A singleton component is still a cycle if the lone member imports
+	// itself directly.
+	member := members[0]
+	for _, dep := range member.Dependencies {
+		if dep == member {
+			member.Cycle = []*Package{member}
+			return
+		}
+	}
+}
+
+// componentDepth computes the topological depth of the SCC at
+// sccMembers[scc], treating it as the request asks: a single collapsed
+// node whose depth is the max depth of any dependency lying *outside*
+// the component, plus one (0 if there are none), or -1 if every member
+// is predeclared or learned. This is what lets a package that merely
+// imports a cyclic package still get a real depth, instead of inheriting
+// ErrCycle transitively the way a naive propagation would. Memoized per
+// SCC index, since componentDepth is called once per external importer.
+func componentDepth(scc int) int {
+
+	if cached, ok := sccDepthCache[scc]; ok {
+		return cached
+	}
+
+	members := sccMembers[scc]
+
+	allDormant := true
+	for _, member := range members {
+		if !member.Predeclared() && !member.Learned {
+			allDormant = false
+			break
+		}
+	}
+	if allDormant {
+		sccDepthCache[scc] = -1
+		return -1
+	}
+
+	inComponent := make(map[*Package]bool, len(members))
+	for _, member := range members {
+		inComponent[member] = true
+	}
+
+	depth := 0
+	for _, member := range members {
+		for _, dep := range member.Dependencies {
+			if inComponent[dep] {
+				continue // internal to the cycle; doesn't add depth.
+			}
+
+			depDepth, err := dep.DependencyDepth()
+			if err != nil {
+				depDepth = componentDepth(sccOf[dep])
+			}
+			if depDepth+1 > depth {
+				depth = depDepth + 1
+			}
+		}
+	}
+
+	sccDepthCache[scc] = depth
+	return depth
+}
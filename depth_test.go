@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// resetDepthState clears the package-level state DependencyDepth and
+// computeSCCs memoize into, so each test case starts from a clean graph.
+// Real runs only ever build one graph per process, but tests build a
+// fresh synthetic one per case.
+func resetDepthState() {
+	pkgsByLocation = make(map[string]*Package, 300)
+	depthCache = make(map[*Package]int)
+	sccDepthCache = make(map[int]int)
+	sccOf = make(map[*Package]int)
+	sccMembers = nil
+	sccsDone = false
+}
+
+// newTestPackage registers a bare Package under pkgsByLocation, the way
+// NewPackage would for a loaded one.
+func newTestPackage(name string) *Package {
+	p := &Package{ImportPath: name}
+	pkgsByLocation[name] = p
+	return p
+}
+
+// TestDependencyDepthCycle builds a synthetic graph containing a 2-package
+// cycle (real Go import graphs can't have one, so this is the only way to
+// exercise computeSCCs/componentDepth's cyclic path):
+//
+//	leaf <- a <-> b
+//	leaf <- c <- a
+//
+// and checks that only a and b, the packages actually in the cycle,
+// report ErrCycle, while c - which merely depends on the cycle - gets a
+// real depth computed from componentDepth.
+func TestDependencyDepthCycle(t *testing.T) {
+	resetDepthState()
+
+	leaf := newTestPackage("leaf")
+	a := newTestPackage("a")
+	b := newTestPackage("b")
+	c := newTestPackage("c")
+
+	a.DependsOn(leaf)
+	a.DependsOn(b)
+	b.DependsOn(a)
+	c.DependsOn(a)
+
+	cases := []struct {
+		pkg       *Package
+		wantDepth int
+		wantErr   error
+	}{
+		{leaf, 0, nil},
+		{a, cycleDepth, ErrCycle},
+		{b, cycleDepth, ErrCycle},
+		{c, 2, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pkg.Name(), func(t *testing.T) {
+			depth, err := tc.pkg.DependencyDepth()
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("DependencyDepth() err = %v, want %v", err, tc.wantErr)
+			}
+			if depth != tc.wantDepth {
+				t.Fatalf("DependencyDepth() depth = %d, want %d", depth, tc.wantDepth)
+			}
+		})
+	}
+
+	if len(a.Cycle) != 1 || a.Cycle[0] != b {
+		t.Fatalf("a.Cycle = %v, want [b]", a.Cycle)
+	}
+	if len(b.Cycle) != 1 || b.Cycle[0] != a {
+		t.Fatalf("b.Cycle = %v, want [a]", b.Cycle)
+	}
+}
+
+// TestDependencyDepthSelfImport checks the singleton-SCC case: a package
+// that imports itself directly is still flagged as a cycle.
+func TestDependencyDepthSelfImport(t *testing.T) {
+	resetDepthState()
+
+	a := newTestPackage("a")
+	a.DependsOn(a)
+
+	_, err := a.DependencyDepth()
+	if !errors.Is(err, ErrCycle) {
+		t.Fatalf("DependencyDepth() err = %v, want %v", err, ErrCycle)
+	}
+	if len(a.Cycle) != 1 || a.Cycle[0] != a {
+		t.Fatalf("a.Cycle = %v, want [a]", a.Cycle)
+	}
+}
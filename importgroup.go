@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Import groups, borrowed from the importToGroup idea in
+// x/tools/internal/imports: lower groups sort and print first, and a
+// blank row separates adjacent groups in the text report, matching the
+// goimports convention for import blocks.
+const (
+	groupStdlib = iota
+	groupThirdParty
+	groupCompany
+	groupLocal
+)
+
+// groupNames gives the -group-summary label for each group.
+var groupNames = map[int]string{
+	groupStdlib:     "stdlib",
+	groupThirdParty: "third-party",
+	groupCompany:    "company",
+	groupLocal:      "local",
+}
+
+// localPrefixes holds the parsed, comma-separated value of -local-prefix.
+var localPrefixes []string
+
+// importGroup classifies importPath into one of the four groups above,
+// checking the most specific rule first: a user -local-prefix match,
+// then the -company-prefix, then "does the first path component look
+// like a domain" (third-party), and finally stdlib.
+func importGroup(importPath string) int {
+
+	for _, prefix := range localPrefixes {
+		if prefix != "" && strings.HasPrefix(importPath, prefix) {
+			return groupLocal
+		}
+	}
+
+	if companyPrefix != "" && strings.HasPrefix(importPath, companyPrefix) {
+		return groupCompany
+	}
+
+	firstComponent := importPath
+	if i := strings.Index(importPath, "/"); i >= 0 {
+		firstComponent = importPath[:i]
+	}
+	if strings.Contains(firstComponent, ".") {
+		return groupThirdParty
+	}
+
+	return groupStdlib
+}
+
+// parseLocalPrefixes splits a comma-separated "-local-prefix" value,
+// trimming whitespace and dropping empty entries.
+func parseLocalPrefixes(raw string) (prefixes []string) {
+
+	if raw == "" {
+		return nil
+	}
+
+	for _, prefix := range strings.Split(raw, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return
+}
+
+// sortedByGroup returns a copy of deps ordered by ImportGroup, then by
+// name within a group, so the text report can lay them out the way
+// goimports lays out an import block.
+func sortedByGroup(deps pkgList) pkgList {
+
+	sorted := make(pkgList, len(deps))
+	copy(sorted, deps)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].ImportGroup != sorted[j].ImportGroup {
+			return sorted[i].ImportGroup < sorted[j].ImportGroup
+		}
+		return sorted[i].Name() < sorted[j].Name()
+	})
+
+	return sorted
+}
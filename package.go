@@ -3,41 +3,68 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
-	"path"
+
+	"golang.org/x/tools/go/packages"
 )
 
-// NewPackage generates a new (valid) Directory. An error is returned
-// when the directory already exists.
-func NewPackage(fullPath string) (pkg *Package, err error) {
+// NewPackage records a *packages.Package loaded by the go/packages
+// loader under its import path. An error is returned when the import
+// path has already been loaded.
+func NewPackage(pkg *packages.Package) (p *Package, err error) {
 
-	if _, ok := pkgsByLocation[fullPath]; ok {
-		err = fmt.Errorf("Package already loaded: %s", fullPath)
+	if _, ok := pkgsByLocation[pkg.PkgPath]; ok {
+		err = fmt.Errorf("Package already loaded: %s", pkg.PkgPath)
 		return
 	}
 
-	if fullPath == standardLibraryPath {
-		pkg = &Package{}
-		pkgsByLocation[fullPath] = pkg
-		return
-	}
+	internal := strings.Contains(pkg.PkgPath, "internal")
 
-	relPath := fullPath[len(standardLibraryPath+"/"):]
-	internal := strings.Contains(relPath, "internal")
-
-	pkg = &Package{RelPath: relPath, Internal: internal}
-	pkgsByLocation[fullPath] = pkg
+	p = &Package{
+		ImportPath:  pkg.PkgPath,
+		Internal:    internal,
+		ImportGroup: importGroup(pkg.PkgPath),
+	}
+	pkgsByLocation[pkg.PkgPath] = p
 	return
 }
 
-// A Package represents a package in the standard library, and
-// enumerates its dependencies.
+// A Package represents a node in the import graph built by the
+// go/packages loader, and enumerates its dependencies.
 type Package struct {
-	RelPath      string
+	ImportPath   string
 	Learned      bool
-	Dependencies pkgList // no duplicates, always sorted.
+	Dependencies pkgList // no duplicates, always sorted. Union across all loaded targets.
 	Dependants   pkgList // a list of Dependants.
 	Internal     bool
+
+	// DepsByTarget holds, for each "GOOS/GOARCH" pair passed via
+	// -targets, the dependencies this package has when built for that
+	// target. It is only populated when -targets is set.
+	DepsByTarget map[string]pkgList
+
+	// CgoSymbols holds the C symbols (e.g. "malloc", "free") referenced
+	// via the C.xxx selector syntax in this package's cgo files. Empty
+	// for packages that don't import "C".
+	CgoSymbols []string
+
+	// CgoPkgConfigPackages holds the pkg-config package names named on
+	// any "#cgo pkg-config: ..." directives in this package's cgo
+	// preamble, and CgoPkgConfigFlags the compiler/linker flags
+	// pkg-config resolved them to.
+	CgoPkgConfigPackages []string
+	CgoPkgConfigFlags    []string
+
+	// Cycle lists the other packages in pkg's strongly connected
+	// component, i.e. the packages it is mutually dependent on. Nil for
+	// a package that isn't part of a cycle. Populated by computeSCCs.
+	Cycle []*Package
+
+	// ImportGroup classifies the package itself (not its dependencies)
+	// into one of groupStdlib/groupThirdParty/groupCompany/groupLocal,
+	// à la goimports. Set once, at NewPackage time.
+	ImportGroup int
 }
 
 // Add a dependency to the package.
@@ -48,49 +75,90 @@ func (pkg *Package) DependsOn(dependency *Package) {
 	pkg.Dependencies.makeUnique()
 }
 
-// Returns the path to the package.
-func (pkg *Package) FullPath() string {
-	return path.Join(standardLibraryPath, pkg.RelPath)
-}
+// addTargetDep records dependency under pkg's DepsByTarget entry for
+// target, keeping the list sorted and duplicate-free.
+func (pkg *Package) addTargetDep(target string, dependency *Package) {
 
-// Returns the packages name (import path).
-func (pkg *Package) Name() string {
+	if pkg.DepsByTarget == nil {
+		pkg.DepsByTarget = make(map[string]pkgList)
+	}
+
+	li := pkg.DepsByTarget[target]
+	li = append(li, dependency)
+	li.Sort()
+	li.makeUnique()
+	pkg.DepsByTarget[target] = li
+}
 
-	if pkg.IsVendor() {
-		return path.Join(strings.Split(pkg.RelPath, "/")[1:]...)
+// onAllTargets reports whether dependency appears in pkg's dependency
+// set for every target that was loaded.
+func (pkg *Package) onAllTargets(dependency *Package) bool {
+
+	for _, deps := range pkg.DepsByTarget {
+		found := false
+		for _, dep := range deps {
+			if dep == dependency {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
 
-	return pkg.RelPath
+	return true
+}
+
+// Returns the package's import path.
+func (pkg *Package) Name() string {
+	return pkg.ImportPath
 }
 
 // The dependency depth of a package is:
 //     -1 (if the pkg is predeclared or learned)
 //     0  (if the pkg has no dependencies)
 //     max(dependency depth of dependencies) + 1 (else)
-func (pkg *Package) DependencyDepth() (depth int) {
+//
+// computeSCCs runs first to collapse any cycle in the dependency graph
+// to a single strongly connected component. A package belonging to a
+// non-trivial component (pkg.Cycle != nil) has no well-defined depth of
+// its own, so DependencyDepth returns cycleDepth and ErrCycle for it -
+// but that only flags pkg itself: an importer of pkg uses
+// componentDepth to get the cycle's real, topologically-computed depth
+// instead of inheriting ErrCycle (and a meaningless depth) transitively
+// through every ancestor. Every other depth is computed once and
+// memoized in depthCache, so a package with many shared dependencies is
+// still only visited once.
+func (pkg *Package) DependencyDepth() (depth int, err error) {
+
+	computeSCCs()
+
+	if pkg.Cycle != nil {
+		return cycleDepth, ErrCycle
+	}
 
-	var importedPkgDepth int
+	if cached, ok := depthCache[pkg]; ok {
+		return cached, nil
+	}
 
 	if pkg.Predeclared() || pkg.Learned {
-		return -1
+		depthCache[pkg] = -1
+		return -1, nil
 	}
 
 	for _, importedPkg := range pkg.Dependencies {
-		importedPkgDepth = importedPkg.DependencyDepth()
-		if importedPkgDepth >= depth {
+		importedPkgDepth, importedErr := importedPkg.DependencyDepth()
+		if importedErr != nil {
+			importedPkgDepth = componentDepth(sccOf[importedPkg])
+		}
+		if importedPkgDepth+1 > depth {
 			depth = importedPkgDepth + 1
 		}
 	}
-	return
-}
-
-// is the directory in the vendor directory?
-func (pkg *Package) IsVendor() bool {
 
-	if strings.Split(pkg.RelPath, "/")[0] == vendorRelPath {
-		return true
-	}
-	return false
+	depthCache[pkg] = depth
+	return depth, nil
 }
 
 func (pkg *Package) Predeclared() bool {
@@ -123,9 +191,8 @@ func (pkg *Package) Write() (n int, err error) {
 	var rowFormat string
 	var δn int
 
-
 	imported := pkg.Imported()
-	dependancyDepth := pkg.DependencyDepth()
+	dependancyDepth := pkg.depthDisplay()
 
 	// Don't print details for non-imported internal packages.
 	if pkg.Internal && !imported {
@@ -139,6 +206,10 @@ func (pkg *Package) Write() (n int, err error) {
 		importFlag = "unimported"
 	}
 
+	if groupSummary {
+		return pkg.writeGroupSummary(dependancyDepth, importFlag)
+	}
+
 	// No dependencies. Print the package information and continue.
 	if len(pkg.Dependencies) == 0 {
 		rowFormat = fmt.Sprintf(noDependenciesRow, pkg.Name(), dependancyDepth, importFlag)
@@ -146,8 +217,19 @@ func (pkg *Package) Write() (n int, err error) {
 		return
 	}
 
-	// There are dependencies. Print package info, then dependencies on each line.
-	for i, dependency := range pkg.Dependencies {
+	// There are dependencies. Print package info, then dependencies on
+	// each line, grouped à la goimports: a blank row separates a run of
+	// stdlib deps from third-party deps, and so on.
+	deps := sortedByGroup(pkg.Dependencies)
+	for i, dependency := range deps {
+
+		if i > 0 && dependency.ImportGroup != deps[i-1].ImportGroup {
+			if δn, err = fmt.Fprintln(outputWriter); err != nil {
+				n += δn
+				return
+			}
+			n += δn
+		}
 
 		dependencyName := dependency.Name()
 		if !dependency.Learned && !dependency.Predeclared() {
@@ -171,6 +253,132 @@ func (pkg *Package) Write() (n int, err error) {
 		}
 	}
 
+	δn, err = pkg.writeCycle()
+	n += δn
+	if err != nil {
+		return
+	}
+
+	δn, err = pkg.writeCgo()
+	n += δn
+	if err != nil {
+		return
+	}
+
+	δn, err = pkg.writeTargets()
+	n += δn
+	return
+}
+
+// writeGroupSummary prints one row per package giving -group-summary
+// counts (e.g. "stdlib=12 third-party=3 local=1") instead of the usual
+// per-dependency rows.
+func (pkg *Package) writeGroupSummary(dependancyDepth, importFlag string) (n int, err error) {
+
+	counts := make(map[int]int, 4)
+	for _, dependency := range pkg.Dependencies {
+		counts[dependency.ImportGroup]++
+	}
+
+	var parts []string
+	for _, group := range []int{groupStdlib, groupThirdParty, groupCompany, groupLocal} {
+		if counts[group] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", groupNames[group], counts[group]))
+		}
+	}
+
+	rowFormat := fmt.Sprintf(noDependenciesRow, pkg.Name(), dependancyDepth, importFlag) + strings.Join(parts, " ")
+	return fmt.Fprintln(outputWriter, rowFormat)
+}
+
+// depthDisplay renders DependencyDepth for the text report: "cycle" for
+// a package caught in one, since its numeric depth is meaningless, and
+// the plain depth otherwise.
+func (pkg *Package) depthDisplay() string {
+
+	depth, err := pkg.DependencyDepth()
+	if err != nil {
+		return "cycle"
+	}
+
+	return strconv.Itoa(depth)
+}
+
+// writeCycle, when pkg sits in a dependency cycle, prints one extra row
+// naming the other packages sharing it.
+func (pkg *Package) writeCycle() (n int, err error) {
+
+	if len(pkg.Cycle) == 0 {
+		return
+	}
+
+	names := make([]string, len(pkg.Cycle))
+	for i, other := range pkg.Cycle {
+		names[i] = other.Name()
+	}
+
+	rowFormat := fmt.Sprintf(cycleRow, strings.Join(names, ", "))
+	return fmt.Fprintln(outputWriter, rowFormat)
+}
+
+// writeCgo, when pkg imports "C", prints one extra row naming the C
+// symbols it references and the native libraries it pulls in via
+// pkg-config, so the report surfaces non-Go dependencies alongside Go
+// ones.
+func (pkg *Package) writeCgo() (n int, err error) {
+
+	if len(pkg.CgoSymbols) == 0 && len(pkg.CgoPkgConfigPackages) == 0 {
+		return
+	}
+
+	var parts []string
+	if len(pkg.CgoSymbols) > 0 {
+		parts = append(parts, "C symbols: "+strings.Join(pkg.CgoSymbols, ", "))
+	}
+	if len(pkg.CgoPkgConfigPackages) > 0 {
+		parts = append(parts, "pkg-config: "+strings.Join(pkg.CgoPkgConfigPackages, ", "))
+	}
+
+	rowFormat := fmt.Sprintf(cgoRow, strings.Join(parts, "; "))
+	return fmt.Fprintln(outputWriter, rowFormat)
+}
+
+// writeTargets prints one row per target in pkg.DepsByTarget, annotating
+// any dependency that isn't common to every loaded target with "only on
+// <target>" so platform-conditional imports (e.g. crypto/rand pulling in
+// syscall only on linux) are visible at a glance. It is a no-op when
+// -targets wasn't set.
+func (pkg *Package) writeTargets() (n int, err error) {
+
+	if len(pkg.DepsByTarget) == 0 {
+		return
+	}
+
+	targets := make([]string, 0, len(pkg.DepsByTarget))
+	for target := range pkg.DepsByTarget {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		names := make([]string, 0, len(pkg.DepsByTarget[target]))
+		for _, dep := range pkg.DepsByTarget[target] {
+			name := dep.Name()
+			if !pkg.onAllTargets(dep) {
+				name += fmt.Sprintf(" (only on %s)", target)
+			}
+			names = append(names, name)
+		}
+
+		rowFormat := fmt.Sprintf(targetRow, pkg.Name(), target, strings.Join(names, ", "))
+		δn, e := fmt.Fprintln(outputWriter, rowFormat)
+		n += δn
+		if e != nil {
+			err = e
+			return
+		}
+	}
+
 	return
 }
 
@@ -192,4 +400,4 @@ func (li *pkgList) makeUnique() {
 		}
 		i++
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Renderer is the sink for a dependency report. RenderPackage renders a
+// single package; renderers that build their output incrementally (text,
+// dot) use it directly while walking pkgsByDepth. RenderAll renders
+// every package in pkgsByDepth, in increasing dependency-depth order,
+// wrapping that walk in whatever header/footer or final encoding the
+// format needs.
+type Renderer interface {
+	RenderPackage(pkg *Package) error
+	RenderAll(pkgsByDepth map[int]pkgList) error
+}
+
+// newRenderer returns the Renderer for the given -format value.
+func newRenderer(format string) (Renderer, error) {
+
+	switch format {
+	case "", "text":
+		return &textRenderer{}, nil
+	case "json":
+		return &jsonRenderer{w: os.Stdout}, nil
+	case "dot":
+		return &dotRenderer{w: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q (want text, json, or dot)", format)
+	}
+}
+
+// renderDepthOrdered walks pkgsByDepth in increasing depth order,
+// calling render for each package, then renders the cycleDepth bucket
+// (packages whose depth is undefined because they're caught in a
+// dependency cycle) last. It factors out the traversal shared by every
+// Renderer's RenderAll.
+func renderDepthOrdered(pkgsByDepth map[int]pkgList, render func(*Package) error) (err error) {
+
+	for depth := 0; ; depth++ {
+		pkgLi, ok := pkgsByDepth[depth]
+		if !ok {
+			break
+		}
+
+		for _, pkg := range pkgLi {
+			if err = render(pkg); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, pkg := range pkgsByDepth[cycleDepth] {
+		if err = render(pkg); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// textRenderer reproduces the tool's original tabwriter report.
+type textRenderer struct{}
+
+func (r *textRenderer) RenderPackage(pkg *Package) (err error) {
+	_, err = pkg.Write()
+	return
+}
+
+func (r *textRenderer) RenderAll(pkgsByDepth map[int]pkgList) (err error) {
+	defer outputWriter.Flush()
+	return renderDepthOrdered(pkgsByDepth, r.RenderPackage)
+}
+
+// jsonPackage is the JSON representation of a Package, suitable for
+// piping to jq. There's no rel_path field: that was a holdover from
+// when a Package was keyed by filesystem directory (see chunk0-1) and
+// would just have duplicated name.
+type jsonPackage struct {
+	Name         string   `json:"name"`
+	Internal     bool     `json:"internal"`
+	Learned      bool     `json:"learned"`
+	Depth        *int     `json:"depth"` // nil for a package caught in a cycle, where depth is meaningless.
+	Imported     bool     `json:"imported"`
+	Dependencies []string `json:"dependencies"`
+	Dependants   []string `json:"dependants"`
+
+	// Native library dependencies pulled in via cgo; omitted for
+	// packages that don't import "C".
+	CgoSymbols           []string `json:"cgo_symbols,omitempty"`
+	CgoPkgConfigPackages []string `json:"cgo_pkg_config_packages,omitempty"`
+	CgoPkgConfigFlags    []string `json:"cgo_pkg_config_flags,omitempty"`
+}
+
+// jsonRenderer emits the full dependency report as one JSON array.
+type jsonRenderer struct {
+	w       io.Writer
+	entries []jsonPackage
+}
+
+func (r *jsonRenderer) RenderPackage(pkg *Package) error {
+
+	var depth *int
+	if d, err := pkg.DependencyDepth(); err == nil {
+		depth = &d
+	}
+
+	r.entries = append(r.entries, jsonPackage{
+		Name:                 pkg.Name(),
+		Internal:             pkg.Internal,
+		Learned:              pkg.Learned,
+		Depth:                depth,
+		Imported:             pkg.Imported(),
+		Dependencies:         pkgNames(pkg.Dependencies),
+		Dependants:           pkgNames(pkg.Dependants),
+		CgoSymbols:           pkg.CgoSymbols,
+		CgoPkgConfigPackages: pkg.CgoPkgConfigPackages,
+		CgoPkgConfigFlags:    pkg.CgoPkgConfigFlags,
+	})
+
+	return nil
+}
+
+func (r *jsonRenderer) RenderAll(pkgsByDepth map[int]pkgList) (err error) {
+
+	if err = renderDepthOrdered(pkgsByDepth, r.RenderPackage); err != nil {
+		return
+	}
+
+	return json.NewEncoder(r.w).Encode(r.entries)
+}
+
+// dotRenderer emits a Graphviz digraph: nodes are packages (shape=box
+// for internal packages, style=dashed for unlearned ones) and edges run
+// dep -> dependant. Non-imported internal packages are skipped, hiding
+// dead internal subtrees the same way the text renderer does.
+type dotRenderer struct {
+	w       io.Writer
+	started bool
+}
+
+func (r *dotRenderer) RenderPackage(pkg *Package) (err error) {
+
+	if !r.started {
+		if _, err = fmt.Fprintln(r.w, "digraph deps {"); err != nil {
+			return
+		}
+		r.started = true
+	}
+
+	if pkg.Internal && !pkg.Imported() {
+		return nil
+	}
+
+	var attrs []string
+	if pkg.Internal {
+		attrs = append(attrs, "shape=box")
+	}
+	if !pkg.Learned && !pkg.Predeclared() {
+		attrs = append(attrs, "style=dashed")
+	}
+
+	if len(attrs) > 0 {
+		_, err = fmt.Fprintf(r.w, "\t%q [%s];\n", pkg.Name(), strings.Join(attrs, ","))
+	} else {
+		_, err = fmt.Fprintf(r.w, "\t%q;\n", pkg.Name())
+	}
+	if err != nil {
+		return
+	}
+
+	for _, dep := range pkg.Dependencies {
+		if dep.Internal && !dep.Imported() {
+			continue
+		}
+		if _, err = fmt.Fprintf(r.w, "\t%q -> %q;\n", dep.Name(), pkg.Name()); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func (r *dotRenderer) RenderAll(pkgsByDepth map[int]pkgList) (err error) {
+
+	if err = renderDepthOrdered(pkgsByDepth, r.RenderPackage); err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintln(r.w, "}")
+	return
+}
+
+// pkgNames returns the import paths of a pkgList, for JSON encoding.
+func pkgNames(li pkgList) []string {
+
+	names := make([]string, len(li))
+	for i, pkg := range li {
+		names[i] = pkg.Name()
+	}
+
+	return names
+}